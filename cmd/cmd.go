@@ -1,24 +1,32 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
-	_ "github.com/lib/pq"
 	"github.com/mattn/go-colorable"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"runtime"
 	"time"
+
+	"github.com/adarien/youtube_playlist_items/store"
+	"github.com/adarien/youtube_playlist_items/store/postgres"
+	"github.com/adarien/youtube_playlist_items/store/sqlite"
+	"github.com/adarien/youtube_playlist_items/ytapi"
 )
 
 // getToken uses a Context and Config to retrieve a Token.
@@ -46,8 +54,23 @@ func getToken(config *oauth2.Config) (*oauth2.Token, error) {
 }
 
 // getTokenFromWeb uses Config to request a Token.
+// The flow used is selected by the OAUTH_MODE setting ("manual" or
+// "loopback"); manual is the default so existing setups keep working
+// unchanged.
 // It returns the retrieved Token and any error encountered.
 func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+	switch viper.GetString("OAUTH_MODE") {
+	case "loopback":
+		return getTokenFromWebLoopback(config)
+	default:
+		return getTokenFromWebManual(config)
+	}
+}
+
+// getTokenFromWebManual asks the user to open the auth URL themselves and
+// paste back the authorization code. It returns the retrieved Token and
+// any error encountered.
+func getTokenFromWebManual(config *oauth2.Config) (*oauth2.Token, error) {
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 	instruction := "Go to the following link in your browser then type the authorization code"
 	fmt.Printf("%s: \n%v\n", instruction, authURL)
@@ -67,6 +90,109 @@ func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
 	return token, nil
 }
 
+// loopbackTimeout bounds how long getTokenFromWebLoopback waits for the
+// user to complete the consent screen before giving up.
+const loopbackTimeout = 2 * time.Minute
+
+// getTokenFromWebLoopback starts a short-lived HTTP server on
+// localhost:OAUTH_LOOPBACK_PORT, points config.RedirectURL at it, opens the
+// auth URL in the user's default browser and waits for the OAuth redirect
+// to deliver the authorization code. It returns the retrieved Token and any
+// error encountered.
+func getTokenFromWebLoopback(config *oauth2.Config) (*oauth2.Token, error) {
+	port := viper.GetString("OAUTH_LOOPBACK_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	redirectURL := fmt.Sprintf("http://localhost:%s/callback", port)
+	config.RedirectURL = redirectURL
+
+	codeCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		if errParam := r.URL.Query().Get("error"); errParam != "" {
+			errCh <- fmt.Errorf("authorization denied: %s", errParam)
+			http.Error(w, "Authorization denied, you can close this tab.", http.StatusBadRequest)
+			return
+		}
+
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			errCh <- fmt.Errorf("redirect did not contain a code parameter")
+			http.Error(w, "Missing authorization code, you can close this tab.", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, "<html><body>Authorization successful, you can close this tab.</body></html>")
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		codeCh <- code
+	})
+
+	server := &http.Server{Addr: fmt.Sprintf("localhost:%s", port), Handler: mux}
+	listenErrCh := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			listenErrCh <- err
+		}
+	}()
+
+	// shutdown closes the listener and then waits (up to 5 seconds) for the
+	// handler that's already writing its response to finish, so the browser
+	// reliably sees the "you can close this tab" page instead of a reset
+	// connection.
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}
+
+	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	fmt.Printf("Opening the following link in your browser: \n%v\n", authURL)
+	if err := openBrowser(authURL); err != nil {
+		fmt.Printf("unable to open browser automatically, open this link manually: %v\n", err)
+	}
+
+	var code string
+	select {
+	case code = <-codeCh:
+	case err := <-errCh:
+		shutdown()
+		return nil, err
+	case err := <-listenErrCh:
+		return nil, fmt.Errorf("unable to start loopback server: %s", err)
+	case <-time.After(loopbackTimeout):
+		shutdown()
+		return nil, fmt.Errorf("timed out waiting for the OAuth redirect after %s", loopbackTimeout)
+	}
+
+	shutdown()
+
+	token, err := config.Exchange(context.Background(), code)
+	if err != nil {
+		err = fmt.Errorf("unable to retrieve token from web: %s", err)
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// openBrowser opens url in the user's default browser using the
+// appropriate command for the current OS.
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("cmd", "/c", "start", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
 // getPathTokenCacheFile generates credential file path/filename.
 // It returns the generated credential path/filename and any error encountered.
 func getPathTokenCacheFile() (string, error) {
@@ -135,128 +261,199 @@ func saveToken(file string, token *oauth2.Token) error {
 	return nil
 }
 
-// getPlaylistsInfo get playlists information
-// It returns PlaylistListResponse struct and any error encountered.
-func getPlaylistsInfo(service *youtube.Service, channelId string) (*youtube.PlaylistListResponse, error) {
-	part := []string{"snippet", "contentDetails"}
-	call := service.Playlists.List(part)
-	if channelId != "" {
-		call = call.ChannelId(channelId)
-	}
-	call = call.MaxResults(25)
+// playlistMeta is the subset of a youtube.Playlist the ingestion pipeline
+// needs once it's ready to walk the playlist's items.
+type playlistMeta struct {
+	ID    string
+	Title string
+	Count int64
+}
 
-	response, err := call.Do()
-	if err != nil {
-		return nil, fmt.Errorf("getPlaylistsInfo not call: %v", err)
+// metaFromPlaylist converts a youtube.Playlist to the playlistMeta the
+// ingestion pipeline works with. Snippet and ContentDetails are only
+// populated when the corresponding part was requested and the API chose
+// to return it, so both are guarded here rather than assumed present.
+func metaFromPlaylist(playlist *youtube.Playlist) playlistMeta {
+	meta := playlistMeta{ID: playlist.Id}
+	if playlist.Snippet != nil {
+		meta.Title = playlist.Snippet.Title
+	}
+	if playlist.ContentDetails != nil {
+		meta.Count = playlist.ContentDetails.ItemCount
 	}
 
-	return response, nil
+	return meta
 }
 
-func getChannelsLists(service *youtube.Service, part []string, username string) (*youtube.ChannelListResponse, error) {
-	call := service.Channels.List(part)
-	call = call.ForUsername(username)
-
-	response, err := call.Do()
+// listPlaylistMeta fetches every playlist owned by channelID and converts
+// it to playlistMeta, skipping the auto-generated "Favorites" playlist.
+func listPlaylistMeta(client *ytapi.Client, channelID string) ([]playlistMeta, error) {
+	playlists, err := client.PlaylistsForChannel(channelID)
 	if err != nil {
-		return nil, fmt.Errorf("channel not call: %v", err)
+		return nil, err
 	}
-	if len(response.Items) == 0 {
-		return nil, fmt.Errorf("incorrect userName")
+
+	var metas []playlistMeta
+	for _, playlist := range playlists {
+		if playlist.Snippet != nil && playlist.Snippet.Title == "Favorites" {
+			continue
+		}
+		metas = append(metas, metaFromPlaylist(playlist))
 	}
 
-	return response, nil
+	return metas, nil
 }
 
-type playlistMeta struct {
-	ID    string
-	Title string
-	Count int64
+// trackFromItem builds the store.Track that gets persisted for a single
+// playlist item.
+func trackFromItem(item *ytapi.Item, meta playlistMeta) store.Track {
+	return store.Track{
+		PlaylistTitle:          meta.Title,
+		VideoID:                item.VideoID,
+		TrackTitle:             item.Title,
+		PublishedAt:            item.PublishedAt,
+		VideoOwnerChannelTitle: item.VideoOwnerChannelTitle,
+		VideoOwnerChannelId:    item.VideoOwnerChannelId,
+		PlaylistID:             meta.ID,
+		Position:               item.Position,
+	}
 }
 
-func getListsID(service *youtube.Service, response *youtube.ChannelListResponse) ([]playlistMeta, error) {
-	channelID := response.Items[0].Id
-	response2, err := getPlaylistsInfo(service, channelID)
+// enrichTrack fills in the video-level metadata on t from video, the
+// Videos.List result for t.VideoID.
+//
+// These fields are persisted through the matching playlists_info columns
+// that each store backend's migrations create.
+func enrichTrack(t *store.Track, video *youtube.Video) {
+	if video.ContentDetails != nil {
+		if d, err := ytapi.ParseISO8601Duration(video.ContentDetails.Duration); err == nil {
+			t.DurationSeconds = int64(d.Seconds())
+		}
+	}
+	if video.Snippet != nil {
+		t.CategoryID = video.Snippet.CategoryId
+		t.ThumbnailURL = ytapi.BestThumbnailURL(video.Snippet.Thumbnails)
+		t.Tags = video.Snippet.Tags
+	}
+	if video.Statistics != nil {
+		t.ViewCount = video.Statistics.ViewCount
+		t.LikeCount = video.Statistics.LikeCount
+		t.CommentCount = video.Statistics.CommentCount
+	}
+}
+
+// sweepPlaylist deletes (or, in dry-run mode, just logs) any track stored
+// for playlistID whose video is no longer in currentVideoIDs, i.e. it was
+// removed from the playlist on YouTube since the last sync.
+func sweepPlaylist(st store.Store, playlistID string, currentVideoIDs []string, dryRun bool) error {
+	stored, err := st.ListTracksByPlaylist(playlistID)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf(" - - - unable to read stored tracks - - - : %s", err)
 	}
 
-	var playlists []playlistMeta
-	for _, playlist := range response2.Items {
-		if playlist.Snippet.Title != "Favorites" {
-			meta := playlistMeta{}
-			meta.ID = playlist.Id
-			meta.Title = playlist.Snippet.Title
-			meta.Count = playlist.ContentDetails.ItemCount
-			playlists = append(playlists, meta)
+	current := make(map[string]bool, len(currentVideoIDs))
+	for _, videoID := range currentVideoIDs {
+		current[videoID] = true
+	}
+
+	var stale []string
+	for _, track := range stored {
+		if !current[track.VideoID] {
+			stale = append(stale, track.VideoID)
 		}
 	}
+	if len(stale) == 0 {
+		return nil
+	}
 
-	return playlists, nil
-}
+	if dryRun {
+		fmt.Printf("dry-run: would delete %d track(s) no longer in playlist %s: %v\n", len(stale), playlistID, stale)
+		return nil
+	}
+
+	fmt.Printf("deleting %d track(s) no longer in playlist %s\n", len(stale), playlistID)
+	for _, videoID := range stale {
+		if err := st.DeleteTrack(playlistID, videoID); err != nil {
+			return fmt.Errorf(" - - - unable to delete stale track - - - : %s", err)
+		}
+	}
 
-type TrackInfo struct {
-	PlaylistTitle          string    `json:"playlistName,omitempty"`
-	VideoID                string    `json:"videoId,omitempty"`
-	TrackTitle             string    `json:"title,omitempty"`
-	PublishedAt            string    `json:"publishedAt,omitempty"`
-	VideoOwnerChannelTitle string    `json:"videoOwnerChannelTitle,omitempty"`
-	VideoOwnerChannelId    string    `json:"videoOwnerChannelId,omitempty"`
-	PlaylistID             string    `json:"playlistid,omitempty"`
-	Position               int64     `json:"position,omitempty"`
-	Created                time.Time `json:"created,omitempty"`
+	return nil
 }
 
-func getItemInfo(conn *ServiceSQL, playlistResponse *youtube.PlaylistItemListResponse, meta playlistMeta) {
-	for _, playlistItem := range playlistResponse.Items {
-		oi := TrackInfo{}
-		oi.PlaylistTitle = meta.Title
-		oi.VideoID = playlistItem.Snippet.ResourceId.VideoId
-		oi.TrackTitle = playlistItem.Snippet.Title
-		oi.PublishedAt = playlistItem.Snippet.PublishedAt
-		oi.VideoOwnerChannelTitle = playlistItem.Snippet.VideoOwnerChannelTitle
-		oi.VideoOwnerChannelId = playlistItem.Snippet.VideoOwnerChannelId
-		oi.PlaylistID = meta.ID
-		oi.Position = playlistItem.Snippet.Position
-
-		// fmt.Println(oi)
-		// fmt.Println(oi.VideoID)
-
-		// record to DB
-		err := conn.PostProduct(oi)
+// defaultMaxConcurrency bounds how many playlists getListItems fans out to
+// at once when MAX_CONCURRENCY isn't set.
+const defaultMaxConcurrency = 4
+
+// syncPlaylist fetches every item in meta, enriches it with video
+// metadata, and upserts/sweeps it against st.
+func syncPlaylist(client *ytapi.Client, st store.Store, meta playlistMeta, dryRun bool) error {
+	fmt.Println(meta.Title, " ", meta.Count)
+
+	var items []*ytapi.Item
+	for item, err := range client.PlaylistItems(meta.ID) {
 		if err != nil {
-			fmt.Println(err)
+			return err
 		}
+		items = append(items, item)
+	}
+
+	videoIDs := make([]string, len(items))
+	for i, item := range items {
+		videoIDs[i] = item.VideoID
+	}
+	details, err := client.VideoDetailsBatch(videoIDs)
+	if err != nil {
+		return err
 	}
+
+	for _, item := range items {
+		track := trackFromItem(item, meta)
+		if video, ok := details[item.VideoID]; ok {
+			enrichTrack(&track, video)
+		}
+
+		if dryRun {
+			fmt.Printf("dry-run: would upsert video %s (%s) in playlist %s\n", track.VideoID, track.TrackTitle, track.PlaylistID)
+			continue
+		}
+		if err := st.UpsertTrack(track); err != nil {
+			return fmt.Errorf(" - - - unable to write to DB - - - : %s", err)
+		}
+	}
+
+	return sweepPlaylist(st, meta.ID, videoIDs, dryRun)
 }
 
-func getListItems(service *youtube.Service, playlistMeta []playlistMeta) error {
-	nextPageToken := ""
-	conn := New()
+// getListItems syncs every playlist in playlistMeta, fanning out across a
+// bounded worker pool (MAX_CONCURRENCY, default defaultMaxConcurrency) so a
+// large channel's playlists are synced concurrently rather than one at a
+// time. As soon as any playlist sync fails, e.g. because the daily quota
+// was exhausted, no further playlist sync is started and that first error
+// is returned; syncs already in progress run to completion.
+func getListItems(client *ytapi.Client, playlistMeta []playlistMeta, st store.Store, dryRun bool) error {
+	maxConcurrency := viper.GetInt("MAX_CONCURRENCY")
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxConcurrency
+	}
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.SetLimit(maxConcurrency)
 
 	for _, meta := range playlistMeta {
-		fmt.Print(meta.Title, " ", meta.Count)
-		for {
-			playlistCall := service.PlaylistItems.List([]string{"snippet"}).
-				PlaylistId(meta.ID).
-				MaxResults(50).
-				PageToken(nextPageToken)
-
-			playlistResponse, err := playlistCall.Do()
-			if err != nil {
-				return fmt.Errorf("error fetching playlist items: %s", err)
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			return syncPlaylist(client, st, meta, dryRun)
+		})
+	}
 
-			getItemInfo(conn, playlistResponse, meta)
-
-			nextPageToken = playlistResponse.NextPageToken
-			if nextPageToken == "" {
-				break
-			}
-		}
-		fmt.Println()
+	if err := g.Wait(); err != nil {
+		return err
 	}
 
+	fmt.Printf("estimated quota units consumed: %d\n", client.QuotaUsed())
 	return nil
 }
 
@@ -288,7 +485,7 @@ func initCredential(CredentialFilePath string) ([]byte, error) {
 	return cs, err
 }
 
-func Run() error {
+func Run(dryRun bool) error {
 	ctx := context.Background()
 	viper.SetConfigFile(".env")
 	if err := viper.ReadInConfig(); err != nil {
@@ -317,18 +514,35 @@ func Run() error {
 		return fmt.Errorf(" - - - unable to create client - - - : %s", err)
 	}
 
-	part := []string{"snippet", "contentDetails"}
-	resp, err := getChannelsLists(service, part, userName)
+	client := ytapi.NewClient(service)
+	resolved, err := client.Resolve(userName)
 	if err != nil {
 		return fmt.Errorf(" - - - unable to get channel list - - - : %s", err)
 	}
 
-	meta, err := getListsID(service, resp)
+	var meta []playlistMeta
+	if resolved.PlaylistID != "" {
+		playlist, err := client.PlaylistByID(resolved.PlaylistID)
+		if err != nil {
+			return fmt.Errorf(" - - - unable to get playlists ID - - - : %s", err)
+		}
+		meta = []playlistMeta{metaFromPlaylist(playlist)}
+	} else {
+		meta, err = listPlaylistMeta(client, resolved.Channel.Id)
+		if err != nil {
+			return fmt.Errorf(" - - - unable to get playlists ID - - - : %s", err)
+		}
+	}
+
+	st, err := openStore()
 	if err != nil {
-		return fmt.Errorf(" - - - unable to get playlists ID - - - : %s", err)
+		return fmt.Errorf(" - - - unable to connect to the database - - - : %s", err)
+	}
+	if err := st.Migrate(); err != nil {
+		return fmt.Errorf(" - - - unable to migrate the database - - - : %s", err)
 	}
 
-	err = getListItems(service, meta)
+	err = getListItems(client, meta, st, dryRun)
 	if err != nil {
 		return fmt.Errorf(" - - - unable to get playlists Items - - - : %s", err)
 	}
@@ -339,70 +553,27 @@ func Run() error {
 func main() {
 	log := initLogger()
 
-	err := Run()
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-type DB struct {
-	DB *sql.DB
-}
-
-type ServiceSQL struct {
-	db *DB
-}
-
-func New() *ServiceSQL {
-	dbClient := Connect()
-	return &ServiceSQL{db: dbClient}
-}
-
-func Connect() *DB {
-	viper.SetConfigFile(".env")
-	if err := viper.ReadInConfig(); err != nil {
-		logrus.Fatal(err)
-	}
+	dryRun := flag.Bool("dry-run", false, "log the sync diff without writing to the database")
+	flag.Parse()
 
-	driverName := viper.GetString("DRIVER")
-	host := viper.GetString("HOST")
-	port := viper.GetString("PORT")
-	userName := viper.GetString("USER")
-	dbname := viper.GetString("DBNAME")
-	sslMode := viper.GetString("SSLMODE")
-	password := viper.GetString("PASSWORD")
-
-	dataSourceName := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s password=%s",
-		host, port, userName, dbname, sslMode, password)
-	// fmt.Println(dataSourceName)
-	db, err := sql.Open(driverName, dataSourceName)
-	if err != nil {
-		logrus.Fatal(err)
-	}
-
-	return &DB{DB: db}
-}
-
-func (db *DB) InsertProductDB(ti TrackInfo) error {
-	tx, err := db.DB.Begin()
+	err := Run(*dryRun)
 	if err != nil {
-		return err
-	}
-	defer func() { _ = tx.Rollback() }()
-
-	query := "insert into playlists_info (playlisttitle, position, videoid, tracktitle, publishedat, playlistid, videoownerchannelid, videoownerchanneltitle) values ($1, $2, $3, $4, $5, $6, $7, $8)"
-	_, err = tx.Exec(query, ti.PlaylistTitle, ti.Position, ti.VideoID, ti.TrackTitle, ti.PublishedAt, ti.PlaylistID, ti.VideoOwnerChannelId, ti.VideoOwnerChannelTitle)
-	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-
-	return tx.Commit()
 }
 
-func (s *ServiceSQL) PostProduct(ti TrackInfo) error {
-	err := s.db.InsertProductDB(ti)
-	if err != nil {
-		return fmt.Errorf(" - - - unable to write to DB - - - : %s", err)
+// openStore builds the store.Store selected by the DRIVER setting
+// ("postgres", the default, or "sqlite").
+func openStore() (store.Store, error) {
+	switch driver := viper.GetString("DRIVER"); driver {
+	case "sqlite":
+		return sqlite.Open(viper.GetString("SQLITE_PATH"))
+	case "postgres", "":
+		dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s password=%s",
+			viper.GetString("HOST"), viper.GetString("PORT"), viper.GetString("USER"),
+			viper.GetString("DBNAME"), viper.GetString("SSLMODE"), viper.GetString("PASSWORD"))
+		return postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unknown DRIVER %q", driver)
 	}
-	return nil
 }
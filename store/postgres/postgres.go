@@ -0,0 +1,115 @@
+// Package postgres is the Postgres-backed store.Store implementation.
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/adarien/youtube_playlist_items/store"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Store persists playlist tracks to Postgres.
+type Store struct {
+	db *sql.DB
+}
+
+// Open connects to Postgres using dsn (a "key=value" libpq connection
+// string). Callers must call Migrate before using the returned Store.
+func Open(dsn string) (*Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Migrate applies every embedded migration. It's safe to call on every
+// startup.
+func (s *Store) Migrate() error {
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		sqlBytes, err := migrations.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.Exec(string(sqlBytes)); err != nil {
+			return fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) UpsertTrack(t store.Track) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `insert into playlists_info
+		(playlisttitle, position, videoid, tracktitle, publishedat, playlistid, videoownerchannelid, videoownerchanneltitle,
+		 durationseconds, categoryid, viewcount, likecount, commentcount, thumbnailurl, tags)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		on conflict (playlistid, videoid) do update set
+			playlisttitle = excluded.playlisttitle,
+			position = excluded.position,
+			tracktitle = excluded.tracktitle,
+			publishedat = excluded.publishedat,
+			videoownerchannelid = excluded.videoownerchannelid,
+			videoownerchanneltitle = excluded.videoownerchanneltitle,
+			durationseconds = excluded.durationseconds,
+			categoryid = excluded.categoryid,
+			viewcount = excluded.viewcount,
+			likecount = excluded.likecount,
+			commentcount = excluded.commentcount,
+			thumbnailurl = excluded.thumbnailurl,
+			tags = excluded.tags`
+	_, err = tx.Exec(query, t.PlaylistTitle, t.Position, t.VideoID, t.TrackTitle, t.PublishedAt, t.PlaylistID, t.VideoOwnerChannelId, t.VideoOwnerChannelTitle,
+		t.DurationSeconds, t.CategoryID, t.ViewCount, t.LikeCount, t.CommentCount, t.ThumbnailURL, pq.Array(t.Tags))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) DeleteTrack(playlistID, videoID string) error {
+	_, err := s.db.Exec("delete from playlists_info where playlistid = $1 and videoid = $2", playlistID, videoID)
+	return err
+}
+
+func (s *Store) ListTracksByPlaylist(playlistID string) ([]store.Track, error) {
+	rows, err := s.db.Query(`select playlisttitle, position, videoid, tracktitle, publishedat, playlistid,
+		videoownerchannelid, videoownerchanneltitle, durationseconds, categoryid, viewcount, likecount,
+		commentcount, thumbnailurl, tags
+		from playlists_info where playlistid = $1`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []store.Track
+	for rows.Next() {
+		var t store.Track
+		if err := rows.Scan(&t.PlaylistTitle, &t.Position, &t.VideoID, &t.TrackTitle, &t.PublishedAt, &t.PlaylistID,
+			&t.VideoOwnerChannelId, &t.VideoOwnerChannelTitle, &t.DurationSeconds, &t.CategoryID, &t.ViewCount,
+			&t.LikeCount, &t.CommentCount, &t.ThumbnailURL, pq.Array(&t.Tags)); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+
+	return tracks, rows.Err()
+}
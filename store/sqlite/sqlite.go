@@ -0,0 +1,133 @@
+// Package sqlite is the SQLite-backed store.Store implementation, for
+// single-user local runs that don't want to provision a Postgres server.
+// It uses modernc.org/sqlite, a cgo-free driver.
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/adarien/youtube_playlist_items/store"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// tagSeparator joins Track.Tags into the single text column SQLite stores
+// them in; none of the characters YouTube allows in a tag.
+const tagSeparator = "\x1f"
+
+// Store persists playlist tracks to a SQLite database file.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (creating if necessary) the SQLite database at path. Callers
+// must call Migrate before using the returned Store.
+//
+// SQLite only allows one writer at a time on a given file, so the
+// connection pool is capped at 1: with ingestion now running playlists
+// concurrently (see MAX_CONCURRENCY), anything higher would let two
+// goroutines attempt overlapping write transactions and have one fail
+// with SQLITE_BUSY instead of simply queuing behind the other.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	return &Store{db: db}, nil
+}
+
+// Migrate applies every embedded migration. It's safe to call on every
+// startup.
+func (s *Store) Migrate() error {
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		sqlBytes, err := migrations.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.Exec(string(sqlBytes)); err != nil {
+			return fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) UpsertTrack(t store.Track) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `insert into playlists_info
+		(playlisttitle, position, videoid, tracktitle, publishedat, playlistid, videoownerchannelid, videoownerchanneltitle,
+		 durationseconds, categoryid, viewcount, likecount, commentcount, thumbnailurl, tags)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict (playlistid, videoid) do update set
+			playlisttitle = excluded.playlisttitle,
+			position = excluded.position,
+			tracktitle = excluded.tracktitle,
+			publishedat = excluded.publishedat,
+			videoownerchannelid = excluded.videoownerchannelid,
+			videoownerchanneltitle = excluded.videoownerchanneltitle,
+			durationseconds = excluded.durationseconds,
+			categoryid = excluded.categoryid,
+			viewcount = excluded.viewcount,
+			likecount = excluded.likecount,
+			commentcount = excluded.commentcount,
+			thumbnailurl = excluded.thumbnailurl,
+			tags = excluded.tags`
+	_, err = tx.Exec(query, t.PlaylistTitle, t.Position, t.VideoID, t.TrackTitle, t.PublishedAt, t.PlaylistID, t.VideoOwnerChannelId, t.VideoOwnerChannelTitle,
+		t.DurationSeconds, t.CategoryID, t.ViewCount, t.LikeCount, t.CommentCount, t.ThumbnailURL, strings.Join(t.Tags, tagSeparator))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) DeleteTrack(playlistID, videoID string) error {
+	_, err := s.db.Exec("delete from playlists_info where playlistid = ? and videoid = ?", playlistID, videoID)
+	return err
+}
+
+func (s *Store) ListTracksByPlaylist(playlistID string) ([]store.Track, error) {
+	rows, err := s.db.Query(`select playlisttitle, position, videoid, tracktitle, publishedat, playlistid,
+		videoownerchannelid, videoownerchanneltitle, durationseconds, categoryid, viewcount, likecount,
+		commentcount, thumbnailurl, tags
+		from playlists_info where playlistid = ?`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []store.Track
+	for rows.Next() {
+		var t store.Track
+		var tags string
+		if err := rows.Scan(&t.PlaylistTitle, &t.Position, &t.VideoID, &t.TrackTitle, &t.PublishedAt, &t.PlaylistID,
+			&t.VideoOwnerChannelId, &t.VideoOwnerChannelTitle, &t.DurationSeconds, &t.CategoryID, &t.ViewCount,
+			&t.LikeCount, &t.CommentCount, &t.ThumbnailURL, &tags); err != nil {
+			return nil, err
+		}
+		if tags != "" {
+			t.Tags = strings.Split(tags, tagSeparator)
+		}
+		tracks = append(tracks, t)
+	}
+
+	return tracks, rows.Err()
+}
@@ -0,0 +1,42 @@
+// Package store defines the persistence contract the ingestion pipeline
+// writes through, so the choice of database backend (Postgres, SQLite,
+// ...) is a matter of which implementation gets wired up in main, not of
+// how the rest of the program is written.
+package store
+
+// Track is a single playlist entry as persisted by a Store.
+type Track struct {
+	PlaylistTitle          string
+	VideoID                string
+	TrackTitle             string
+	PublishedAt            string
+	VideoOwnerChannelTitle string
+	VideoOwnerChannelId    string
+	PlaylistID             string
+	Position               int64
+	DurationSeconds        int64
+	CategoryID             string
+	ViewCount              uint64
+	LikeCount              uint64
+	CommentCount           uint64
+	ThumbnailURL           string
+	Tags                   []string
+}
+
+// Store is a pluggable storage backend for playlist tracks.
+type Store interface {
+	// Migrate brings the backend's schema up to date. It must be safe to
+	// call on every startup.
+	Migrate() error
+
+	// UpsertTrack inserts t, or updates the existing row for
+	// (t.PlaylistID, t.VideoID) if one is already present.
+	UpsertTrack(t Track) error
+
+	// DeleteTrack removes the row for (playlistID, videoID), if any.
+	DeleteTrack(playlistID, videoID string) error
+
+	// ListTracksByPlaylist returns every track currently stored for
+	// playlistID.
+	ListTracksByPlaylist(playlistID string) ([]Track, error)
+}
@@ -0,0 +1,60 @@
+package ytapi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// reISO8601Duration matches the subset of ISO 8601 durations YouTube
+// returns in contentDetails.duration, e.g. "PT4M13S" or "PT1H2M".
+var reISO8601Duration = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
+// ParseISO8601Duration parses a YouTube contentDetails.duration string
+// (e.g. "PT4M13S") into a time.Duration.
+func ParseISO8601Duration(s string) (time.Duration, error) {
+	m := reISO8601Duration.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid ISO 8601 duration: %q", s)
+	}
+
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration: %q", s)
+		}
+		d += time.Duration(n) * unit
+	}
+
+	return d, nil
+}
+
+// BestThumbnailURL returns the highest-resolution thumbnail URL available
+// on thumbnails, or an empty string if none are set.
+func BestThumbnailURL(thumbnails *youtube.ThumbnailDetails) string {
+	if thumbnails == nil {
+		return ""
+	}
+
+	switch {
+	case thumbnails.Maxres != nil:
+		return thumbnails.Maxres.Url
+	case thumbnails.Standard != nil:
+		return thumbnails.Standard.Url
+	case thumbnails.High != nil:
+		return thumbnails.High.Url
+	case thumbnails.Medium != nil:
+		return thumbnails.Medium.Url
+	case thumbnails.Default != nil:
+		return thumbnails.Default.Url
+	default:
+		return ""
+	}
+}
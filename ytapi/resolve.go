@@ -0,0 +1,81 @@
+package ytapi
+
+import (
+	"fmt"
+	"regexp"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+var (
+	reChannelID     = regexp.MustCompile(`^UC[\w-]{10,}$`)
+	reHandle        = regexp.MustCompile(`^@[\w.-]+$`)
+	rePlaylistParam = regexp.MustCompile(`[?&]list=([\w-]+)`)
+	reChannelURL    = regexp.MustCompile(`youtube\.com/channel/(UC[\w-]+)`)
+	reHandleURL     = regexp.MustCompile(`youtube\.com/(@[\w.-]+)`)
+	reUserURL       = regexp.MustCompile(`youtube\.com/user/([\w-]+)`)
+)
+
+// Resolved is what a user-supplied channel or playlist reference resolved
+// to. Exactly one of Channel or PlaylistID is set: a playlist URL resolves
+// straight to a playlist ID without needing a channel lookup at all.
+type Resolved struct {
+	Channel    *youtube.Channel
+	PlaylistID string
+}
+
+// Resolve accepts a legacy username, a "@handle", a "UC..." channel ID, or
+// a youtube.com channel/handle/user/playlist URL, detects which form it is
+// and dispatches to the matching API call.
+func (c *Client) Resolve(input string) (*Resolved, error) {
+	if m := rePlaylistParam.FindStringSubmatch(input); m != nil {
+		return &Resolved{PlaylistID: m[1]}, nil
+	}
+
+	switch {
+	case reChannelURL.MatchString(input):
+		id := reChannelURL.FindStringSubmatch(input)[1]
+		channel, err := c.ChannelByID(id)
+		if err != nil {
+			return nil, err
+		}
+		return &Resolved{Channel: channel}, nil
+
+	case reHandleURL.MatchString(input):
+		handle := reHandleURL.FindStringSubmatch(input)[1]
+		channel, err := c.ChannelByHandle(handle)
+		if err != nil {
+			return nil, err
+		}
+		return &Resolved{Channel: channel}, nil
+
+	case reUserURL.MatchString(input):
+		username := reUserURL.FindStringSubmatch(input)[1]
+		channel, err := c.ChannelByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		return &Resolved{Channel: channel}, nil
+
+	case reHandle.MatchString(input):
+		channel, err := c.ChannelByHandle(input)
+		if err != nil {
+			return nil, err
+		}
+		return &Resolved{Channel: channel}, nil
+
+	case reChannelID.MatchString(input):
+		channel, err := c.ChannelByID(input)
+		if err != nil {
+			return nil, err
+		}
+		return &Resolved{Channel: channel}, nil
+
+	default:
+		channel, err := c.ChannelByUsername(input)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve %q to a channel: %s", input, err)
+		}
+		return &Resolved{Channel: channel}, nil
+	}
+}
@@ -0,0 +1,69 @@
+package ytapi
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// maxAttempts bounds how many times withRetry will call fn, the first
+// attempt included, before giving up.
+const maxAttempts = 5
+
+// sleep is overridable so tests can exercise withRetry without actually
+// waiting out the backoff.
+var sleep = time.Sleep
+
+// withRetry calls fn, retrying on transient YouTube API errors with
+// exponential backoff and jitter. rateLimitExceeded/userRateLimitExceeded
+// back off longer than a plain server error, and quotaExceeded aborts
+// immediately since the daily quota is spent and retrying can't help.
+func withRetry(fn func() error) error {
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+
+		var apiErr *googleapi.Error
+		if !errors.As(err, &apiErr) {
+			return err
+		}
+
+		switch reason := errorReason(apiErr); {
+		case reason == "quotaExceeded":
+			return fmt.Errorf("daily quota exceeded, aborting: %w", err)
+		case reason == "rateLimitExceeded" || reason == "userRateLimitExceeded":
+			sleep(backoff(attempt) + 10*time.Second)
+		case apiErr.Code == 500 || apiErr.Code == 503 || reason == "backendError":
+			sleep(backoff(attempt))
+		default:
+			return err
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", maxAttempts, lastErr)
+}
+
+// errorReason returns the first reason code on a googleapi.Error (e.g.
+// "quotaExceeded", "rateLimitExceeded", "backendError"), or "" if the
+// error didn't carry one.
+func errorReason(err *googleapi.Error) string {
+	if len(err.Errors) == 0 {
+		return ""
+	}
+	return err.Errors[0].Reason
+}
+
+// backoff returns an exponentially increasing delay for the given
+// (zero-based) retry attempt: 2^attempt seconds plus a random jitter of
+// less than that same amount.
+func backoff(attempt int) time.Duration {
+	base := time.Duration(1<<uint(attempt)) * time.Second
+	return base + time.Duration(rand.Int63n(int64(base)))
+}
@@ -0,0 +1,267 @@
+// Package ytapi wraps the YouTube Data API v3 calls used by the playlist
+// ingestion pipeline behind a small typed Client, so the rest of the
+// program (DB, OAuth, config) doesn't need to know about the raw
+// google.golang.org/api/youtube/v3 types, and so the calls can be mocked
+// in tests.
+package ytapi
+
+import (
+	"fmt"
+	"iter"
+	"sync/atomic"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// listCallQuotaCost is the quota cost, in units, of a single list call
+// (channels.list, playlists.list, playlistItems.list or videos.list all
+// cost the same 1 unit per the YouTube Data API quota calculator).
+const listCallQuotaCost = 1
+
+// Client performs YouTube Data API calls against an authenticated
+// *youtube.Service.
+type Client struct {
+	service   *youtube.Service
+	quotaUsed int64
+}
+
+// NewClient returns a Client that issues calls through service.
+func NewClient(service *youtube.Service) *Client {
+	return &Client{service: service}
+}
+
+// QuotaUsed returns the estimated number of quota units this Client has
+// spent so far, across every call made through it.
+func (c *Client) QuotaUsed() int64 {
+	return atomic.LoadInt64(&c.quotaUsed)
+}
+
+func (c *Client) addQuota(units int64) {
+	atomic.AddInt64(&c.quotaUsed, units)
+}
+
+// ChannelByUsername looks up a channel by its legacy username.
+// It returns an error if the username doesn't resolve to any channel.
+func (c *Client) ChannelByUsername(username string) (*youtube.Channel, error) {
+	call := c.service.Channels.List([]string{"snippet", "contentDetails"}).ForUsername(username)
+	var response *youtube.ChannelListResponse
+	err := withRetry(func() error {
+		var err error
+		response, err = call.Do()
+		return err
+	})
+	c.addQuota(listCallQuotaCost)
+	if err != nil {
+		return nil, fmt.Errorf("channel not call: %v", err)
+	}
+	if len(response.Items) == 0 {
+		return nil, fmt.Errorf("incorrect userName")
+	}
+
+	return response.Items[0], nil
+}
+
+// ChannelByID looks up a channel by its channel ID (UC...).
+// It returns an error if the ID doesn't resolve to any channel.
+func (c *Client) ChannelByID(id string) (*youtube.Channel, error) {
+	call := c.service.Channels.List([]string{"snippet", "contentDetails"}).Id(id)
+	var response *youtube.ChannelListResponse
+	err := withRetry(func() error {
+		var err error
+		response, err = call.Do()
+		return err
+	})
+	c.addQuota(listCallQuotaCost)
+	if err != nil {
+		return nil, fmt.Errorf("channel not call: %v", err)
+	}
+	if len(response.Items) == 0 {
+		return nil, fmt.Errorf("incorrect channel id")
+	}
+
+	return response.Items[0], nil
+}
+
+// ChannelByHandle looks up a channel by its "@handle".
+// It returns an error if the handle doesn't resolve to any channel.
+func (c *Client) ChannelByHandle(handle string) (*youtube.Channel, error) {
+	call := c.service.Channels.List([]string{"snippet", "contentDetails"}).ForHandle(handle)
+	var response *youtube.ChannelListResponse
+	err := withRetry(func() error {
+		var err error
+		response, err = call.Do()
+		return err
+	})
+	c.addQuota(listCallQuotaCost)
+	if err != nil {
+		return nil, fmt.Errorf("channel not call: %v", err)
+	}
+	if len(response.Items) == 0 {
+		return nil, fmt.Errorf("unable to resolve handle %q to a channel", handle)
+	}
+
+	return response.Items[0], nil
+}
+
+// PlaylistByID looks up a single playlist by its ID, for the case where
+// the caller was handed a playlist URL directly rather than a channel.
+// It returns an error if the ID doesn't resolve to any playlist.
+func (c *Client) PlaylistByID(id string) (*youtube.Playlist, error) {
+	call := c.service.Playlists.List([]string{"snippet", "contentDetails"}).Id(id)
+	var response *youtube.PlaylistListResponse
+	err := withRetry(func() error {
+		var err error
+		response, err = call.Do()
+		return err
+	})
+	c.addQuota(listCallQuotaCost)
+	if err != nil {
+		return nil, fmt.Errorf("getPlaylistsInfo not call: %v", err)
+	}
+	if len(response.Items) == 0 {
+		return nil, fmt.Errorf("incorrect playlist id")
+	}
+
+	return response.Items[0], nil
+}
+
+// PlaylistsForChannel returns every playlist owned by channelID, following
+// the page token until the last page.
+func (c *Client) PlaylistsForChannel(channelID string) ([]*youtube.Playlist, error) {
+	var playlists []*youtube.Playlist
+	pageToken := ""
+	for {
+		call := c.service.Playlists.List([]string{"snippet", "contentDetails"})
+		if channelID != "" {
+			call = call.ChannelId(channelID)
+		}
+		call = call.MaxResults(25)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		var response *youtube.PlaylistListResponse
+		err := withRetry(func() error {
+			var err error
+			response, err = call.Do()
+			return err
+		})
+		c.addQuota(listCallQuotaCost)
+		if err != nil {
+			return nil, fmt.Errorf("getPlaylistsInfo not call: %v", err)
+		}
+
+		playlists = append(playlists, response.Items...)
+		pageToken = response.NextPageToken
+		if pageToken == "" {
+			return playlists, nil
+		}
+	}
+}
+
+// Item is a single entry returned from a playlist, trimmed down to the
+// fields the ingestion pipeline persists.
+type Item struct {
+	VideoID                string
+	Title                  string
+	PublishedAt            string
+	VideoOwnerChannelTitle string
+	VideoOwnerChannelId    string
+	Position               int64
+}
+
+// PlaylistItems returns an iterator over every item in playlistID, hiding
+// the page token bookkeeping from the caller. Each playlist gets its own
+// page token, so unlike the old getListItems loop a token can't leak from
+// one playlist into the next.
+func (c *Client) PlaylistItems(playlistID string) iter.Seq2[*Item, error] {
+	return func(yield func(*Item, error) bool) {
+		pageToken := ""
+		for {
+			call := c.service.PlaylistItems.List([]string{"snippet"}).
+				PlaylistId(playlistID).
+				MaxResults(50)
+			if pageToken != "" {
+				call = call.PageToken(pageToken)
+			}
+
+			var response *youtube.PlaylistItemListResponse
+			err := withRetry(func() error {
+				var err error
+				response, err = call.Do()
+				return err
+			})
+			c.addQuota(listCallQuotaCost)
+			if err != nil {
+				yield(nil, fmt.Errorf("error fetching playlist items: %s", err))
+				return
+			}
+
+			for _, playlistItem := range response.Items {
+				item := &Item{
+					VideoID:                playlistItem.Snippet.ResourceId.VideoId,
+					Title:                  playlistItem.Snippet.Title,
+					PublishedAt:            playlistItem.Snippet.PublishedAt,
+					VideoOwnerChannelTitle: playlistItem.Snippet.VideoOwnerChannelTitle,
+					VideoOwnerChannelId:    playlistItem.Snippet.VideoOwnerChannelId,
+					Position:               playlistItem.Snippet.Position,
+				}
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			pageToken = response.NextPageToken
+			if pageToken == "" {
+				return
+			}
+		}
+	}
+}
+
+// videoDetailsBatchSize is the maximum number of video IDs the YouTube
+// Data API accepts in a single Videos.List call.
+const videoDetailsBatchSize = 50
+
+// VideoDetails fetches statistics, duration and category for up to 50
+// video IDs in a single call. Callers with more IDs should use
+// VideoDetailsBatch, which chunks for them.
+func (c *Client) VideoDetails(ids []string) ([]*youtube.Video, error) {
+	call := c.service.Videos.List([]string{"contentDetails", "statistics", "snippet"}).Id(ids...)
+	var response *youtube.VideoListResponse
+	err := withRetry(func() error {
+		var err error
+		response, err = call.Do()
+		return err
+	})
+	c.addQuota(listCallQuotaCost)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching video details: %s", err)
+	}
+
+	return response.Items, nil
+}
+
+// VideoDetailsBatch fetches statistics, duration and category for any
+// number of video IDs, chunking the underlying Videos.List calls into
+// groups of videoDetailsBatchSize. It returns the results keyed by video
+// ID.
+func (c *Client) VideoDetailsBatch(ids []string) (map[string]*youtube.Video, error) {
+	details := make(map[string]*youtube.Video, len(ids))
+	for start := 0; start < len(ids); start += videoDetailsBatchSize {
+		end := start + videoDetailsBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		videos, err := c.VideoDetails(ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, video := range videos {
+			details[video.Id] = video
+		}
+	}
+
+	return details, nil
+}